@@ -0,0 +1,206 @@
+package capacityfs
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// cowCapacityFs layers a writable, capacity-limited overlay on top of a
+// read-only base filesystem, afero.CopyOnWriteFs-style: reads that hit
+// the base never touch the quota, and only bytes materialized into the
+// overlay (via a write, a create, or a copy-on-write triggered by
+// opening a base file for writing) count against limitedSize.
+//
+// Deleting a path that only exists in base can't be expressed as a
+// write to base, so it is recorded as a whiteout instead: the path is
+// hidden from reads until something re-creates it, at which point the
+// whiteout is cleared and accounting resumes as normal for the new
+// overlay copy.
+type cowCapacityFs struct {
+	base    afero.Fs
+	overlay *capacityFs
+	inner   afero.Fs
+
+	whiteouts map[string]bool
+	mtx       sync.RWMutex
+}
+
+// NewCopyOnWriteCapacityFs returns an afero.Fs that reads through to
+// base for free and debits only the overlay's bytes against size.
+//
+// This function will return an error if base or overlay is nil, or if
+// overlay's existing contents already exceed size (see NewCapacityFs).
+func NewCopyOnWriteCapacityFs(base, overlay afero.Fs, size int64) (afero.Fs, error) {
+	if base == nil || overlay == nil {
+		return nil, fmt.Errorf("nil interface")
+	}
+
+	cfs, err := NewCapacityFs(overlay, size)
+	if err != nil {
+		return nil, err
+	}
+
+	ofs := cfs.(*capacityFs)
+
+	return &cowCapacityFs{
+		base:      base,
+		overlay:   ofs,
+		inner:     afero.NewCopyOnWriteFs(base, ofs),
+		whiteouts: make(map[string]bool),
+	}, nil
+}
+
+// isWhiteout reports whether name, or any directory above it, has been
+// whited-out — so RemoveAll on a directory that only exists in base
+// also hides everything underneath it, not just the directory path
+// itself.
+func (c *cowCapacityFs) isWhiteout(name string) bool {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, p := range possibleCombinations(strings.Split(name, "/")) {
+		if c.whiteouts[p] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *cowCapacityFs) setWhiteout(name string, whiteout bool) {
+	c.mtx.Lock()
+	if whiteout {
+		c.whiteouts[name] = true
+	} else {
+		delete(c.whiteouts, name)
+	}
+	c.mtx.Unlock()
+}
+
+// Create always materializes name into the overlay, clearing any
+// whiteout so the new file is visible again.
+func (c *cowCapacityFs) Create(name string) (afero.File, error) {
+	c.setWhiteout(name, false)
+	return c.overlay.Create(name)
+}
+
+// Mkdir always materializes name into the overlay.
+func (c *cowCapacityFs) Mkdir(name string, perm os.FileMode) error {
+	c.setWhiteout(name, false)
+	return c.overlay.Mkdir(name, perm)
+}
+
+// MkdirAll always materializes name into the overlay.
+func (c *cowCapacityFs) MkdirAll(name string, perm os.FileMode) error {
+	c.setWhiteout(name, false)
+	return c.overlay.MkdirAll(name, perm)
+}
+
+// Open opens name for reading, preferring the overlay's copy if one
+// has been materialized and falling through to base otherwise. Names
+// under a whiteout are hidden even if they still exist in base.
+func (c *cowCapacityFs) Open(name string) (afero.File, error) {
+	if c.isWhiteout(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	return c.inner.Open(name)
+}
+
+// OpenFile opens name, copying it into the overlay first if it is
+// opened for writing and only exists in base (the copy-on-write step),
+// so the bytes start counting against the quota from that point on.
+func (c *cowCapacityFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if c.isWhiteout(name) {
+		if flag&os.O_CREATE == 0 {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+
+		c.setWhiteout(name, false)
+		return c.overlay.OpenFile(name, flag, perm)
+	}
+
+	fi, err := c.inner.OpenFile(name, flag, perm)
+	if err == nil && flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		c.setWhiteout(name, false)
+	}
+
+	return fi, err
+}
+
+// Remove removes name. If it has been materialized into the overlay,
+// the overlay's accounting is debited; either way the path is hidden
+// behind a whiteout so a same-named base file doesn't resurface.
+func (c *cowCapacityFs) Remove(name string) error {
+	if _, err := c.overlay.Fs.Stat(name); err == nil {
+		if err := c.overlay.Remove(name); err != nil {
+			return err
+		}
+	} else if _, err := c.base.Stat(name); err != nil {
+		return err
+	}
+
+	c.setWhiteout(name, true)
+
+	return nil
+}
+
+// RemoveAll is the recursive form of Remove.
+func (c *cowCapacityFs) RemoveAll(name string) error {
+	if _, err := c.overlay.Fs.Stat(name); err == nil {
+		if err := c.overlay.RemoveAll(name); err != nil {
+			return err
+		}
+	} else if _, err := c.base.Stat(name); err != nil {
+		return nil
+	}
+
+	c.setWhiteout(name, true)
+
+	return nil
+}
+
+// Rename is only supported within the overlay; base content must be
+// materialized (via OpenFile or Create) before it can be renamed.
+func (c *cowCapacityFs) Rename(oldname, newname string) error {
+	if err := c.overlay.Rename(oldname, newname); err != nil {
+		return err
+	}
+
+	c.setWhiteout(oldname, true)
+	c.setWhiteout(newname, false)
+
+	return nil
+}
+
+// Stat stats name, preferring the overlay's copy and respecting
+// whiteouts the same way Open does.
+func (c *cowCapacityFs) Stat(name string) (os.FileInfo, error) {
+	if c.isWhiteout(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+
+	return c.inner.Stat(name)
+}
+
+// Name describes the composed filesystem.
+func (c *cowCapacityFs) Name() string {
+	return "cowCapacityFs"
+}
+
+func (c *cowCapacityFs) Chmod(name string, mode os.FileMode) error {
+	return c.inner.Chmod(name, mode)
+}
+
+func (c *cowCapacityFs) Chtimes(name string, atime, mtime time.Time) error {
+	return c.inner.Chtimes(name, atime, mtime)
+}
+
+func (c *cowCapacityFs) Chown(name string, uid, gid int) error {
+	return c.inner.Chown(name, uid, gid)
+}
@@ -0,0 +1,104 @@
+package capacityfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/spf13/afero"
+)
+
+func TestNewCopyOnWriteCapacityFs(t *testing.T) {
+	is := is.New(t)
+
+	_, err := NewCopyOnWriteCapacityFs(nil, afero.NewMemMapFs(), 10)
+	is.True(err != nil)
+
+	_, err = NewCopyOnWriteCapacityFs(afero.NewMemMapFs(), nil, 10)
+	is.True(err != nil)
+
+	_, err = NewCopyOnWriteCapacityFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 42+10)
+	is.NoErr(err)
+}
+
+func TestCowCapacityFsReadsAreFree(t *testing.T) {
+	is := is.New(t)
+
+	base := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(base, "big.txt", []byte("a very large base file"), 0755))
+
+	// base's size never touches the overlay's quota, so the overlay
+	// only needs room for its own (empty) root dir.
+	cfs, err := NewCopyOnWriteCapacityFs(base, afero.NewMemMapFs(), 42)
+	is.NoErr(err)
+
+	b, err := afero.ReadFile(cfs, "big.txt")
+	is.NoErr(err)
+	is.Equal(string(b), "a very large base file")
+
+	is.Equal(cfs.(*cowCapacityFs).overlay.cachedSize, int64(42))
+}
+
+func TestCowCapacityFsWritesHitOverlay(t *testing.T) {
+	is := is.New(t)
+
+	base := afero.NewMemMapFs()
+	overlay := afero.NewMemMapFs()
+
+	cfs, err := NewCopyOnWriteCapacityFs(base, overlay, 42+10)
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(cfs, "ok.txt", []byte("asdf"), 0755))
+
+	ok, err := afero.Exists(overlay, "ok.txt")
+	is.NoErr(err)
+	is.True(ok)
+
+	is.Equal(cfs.(*cowCapacityFs).overlay.cachedSize, int64(42+4))
+
+	err = afero.WriteFile(cfs, "toobig.txt", []byte("way too much data"), 0755)
+	is.Equal(err, ErrNotEnoughCapacity)
+}
+
+func TestCowCapacityFsRemoveWhiteout(t *testing.T) {
+	is := is.New(t)
+
+	base := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(base, "base.txt", []byte("hi"), 0755))
+
+	cfs, err := NewCopyOnWriteCapacityFs(base, afero.NewMemMapFs(), 1024)
+	is.NoErr(err)
+
+	is.NoErr(cfs.Remove("base.txt"))
+
+	_, err = cfs.Stat("base.txt")
+	is.True(os.IsNotExist(err))
+
+	is.NoErr(afero.WriteFile(cfs, "base.txt", []byte("new content"), 0755))
+
+	b, err := afero.ReadFile(cfs, "base.txt")
+	is.NoErr(err)
+	is.Equal(string(b), "new content")
+	is.Equal(cfs.(*cowCapacityFs).overlay.cachedSize, int64(42+len("new content")))
+}
+
+func TestCowCapacityFsRemoveAllWhiteoutsChildren(t *testing.T) {
+	is := is.New(t)
+
+	base := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(base, "dir/child.txt", []byte("hi"), 0755))
+
+	cfs, err := NewCopyOnWriteCapacityFs(base, afero.NewMemMapFs(), 1024)
+	is.NoErr(err)
+
+	is.NoErr(cfs.RemoveAll("dir"))
+
+	_, err = cfs.Stat("dir")
+	is.True(os.IsNotExist(err))
+
+	_, err = cfs.Open("dir/child.txt")
+	is.True(os.IsNotExist(err))
+
+	_, err = afero.ReadFile(cfs, "dir/child.txt")
+	is.True(err != nil)
+}
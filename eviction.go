@@ -0,0 +1,365 @@
+package capacityfs
+
+import (
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// OpKind identifies the kind of filesystem operation an EvictionPolicy
+// is being told about via Observe.
+type OpKind int
+
+const (
+	OpOpen OpKind = iota
+	OpCreate
+	OpWrite
+	OpStat
+)
+
+// EvictionPolicy decides which paths to remove when a write or create
+// would otherwise exceed a capacityFs's quota, turning a hard-limit
+// gate into a bounded cache filesystem.
+type EvictionPolicy interface {
+	// Observe records that path was touched by op. Open, OpenFile,
+	// Create, Write, WriteAt, and Stat all call this on every
+	// operation, successful or not, so policies can maintain access
+	// metadata (recency, size, age).
+	Observe(path string, op OpKind)
+	// Evict returns candidate paths to remove, ordered from most to
+	// least preferred victim. The caller removes them one at a time,
+	// retrying the operation after each, until it succeeds or the
+	// candidates run out.
+	Evict() []string
+	// Forget tells the policy that path has actually been evicted, so
+	// it stops tracking it and never re-offers it as a victim.
+	Forget(path string)
+}
+
+// evictingCapacityFs wraps a capacityFs so that operations which would
+// fail with ErrNotEnoughCapacity instead consult an EvictionPolicy for
+// victims to RemoveAll before retrying.
+type evictingCapacityFs struct {
+	*capacityFs
+	policy EvictionPolicy
+}
+
+// NewCapacityFsWithEviction returns a capacity-limited afero.Fs that,
+// instead of simply rejecting writes once full, asks policy for paths
+// to evict and retries.
+//
+// This function will return an error under the same conditions as
+// NewCapacityFs.
+func NewCapacityFsWithEviction(f afero.Fs, size int64, policy EvictionPolicy) (afero.Fs, error) {
+	cfs, err := NewCapacityFs(f, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &evictingCapacityFs{capacityFs: cfs.(*capacityFs), policy: policy}, nil
+}
+
+// evictAndRetry runs op, and for as long as it fails with
+// ErrNotEnoughCapacity, removes the policy's victims one at a time,
+// retrying op after each removal, stopping as soon as op succeeds,
+// fails with some other error, or the policy runs out of victims. If a
+// full pass over the offered victims frees no capacity at all, it
+// stops there too, so a policy that keeps re-offering paths that are
+// already gone can't loop forever.
+func (e *evictingCapacityFs) evictAndRetry(op func() error) error {
+	err := op()
+
+	for err == ErrNotEnoughCapacity {
+		victims := e.policy.Evict()
+		if len(victims) == 0 {
+			break
+		}
+
+		freedAny := false
+		for _, victim := range victims {
+			before := e.snapshotCachedSize()
+			if rmErr := e.capacityFs.RemoveAll(victim); rmErr != nil {
+				continue
+			}
+			e.policy.Forget(victim)
+
+			if e.snapshotCachedSize() != before {
+				freedAny = true
+			}
+
+			err = op()
+			if err != ErrNotEnoughCapacity {
+				return err
+			}
+		}
+
+		if !freedAny {
+			break
+		}
+	}
+
+	return err
+}
+
+// snapshotCachedSize returns the current cachedSize under a read lock.
+func (e *evictingCapacityFs) snapshotCachedSize() int64 {
+	e.mtx.RLock()
+	defer e.mtx.RUnlock()
+
+	return e.cachedSize
+}
+
+// wrapFile wraps a *fileSize so its Write and WriteAt go through
+// evictAndRetry too.
+func (e *evictingCapacityFs) wrapFile(fi afero.File) afero.File {
+	fs, ok := fi.(*fileSize)
+	if !ok {
+		return fi
+	}
+
+	return &evictingFile{fileSize: fs, fs: e}
+}
+
+// Create creates name, evicting and retrying if it doesn't fit.
+func (e *evictingCapacityFs) Create(name string) (afero.File, error) {
+	e.policy.Observe(name, OpCreate)
+
+	var fi afero.File
+	err := e.evictAndRetry(func() error {
+		var err error
+		fi, err = e.capacityFs.Create(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.wrapFile(fi), nil
+}
+
+// Mkdir creates name, evicting and retrying if it doesn't fit.
+func (e *evictingCapacityFs) Mkdir(name string, perm os.FileMode) error {
+	e.policy.Observe(name, OpCreate)
+
+	return e.evictAndRetry(func() error {
+		return e.capacityFs.Mkdir(name, perm)
+	})
+}
+
+// MkdirAll creates name, evicting and retrying if it doesn't fit.
+func (e *evictingCapacityFs) MkdirAll(name string, perm os.FileMode) error {
+	e.policy.Observe(name, OpCreate)
+
+	return e.evictAndRetry(func() error {
+		return e.capacityFs.MkdirAll(name, perm)
+	})
+}
+
+// Open opens name and reports the access to the policy.
+func (e *evictingCapacityFs) Open(name string) (afero.File, error) {
+	e.policy.Observe(name, OpOpen)
+
+	fi, err := e.capacityFs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.wrapFile(fi), nil
+}
+
+// OpenFile opens name and reports the access to the policy.
+func (e *evictingCapacityFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	e.policy.Observe(name, OpOpen)
+
+	fi, err := e.capacityFs.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.wrapFile(fi), nil
+}
+
+// Stat stats name and reports the access to the policy.
+func (e *evictingCapacityFs) Stat(name string) (os.FileInfo, error) {
+	e.policy.Observe(name, OpStat)
+
+	return e.capacityFs.Stat(name)
+}
+
+// evictingFile wraps a fileSize so Write and WriteAt evict and retry
+// on ErrNotEnoughCapacity instead of failing outright.
+type evictingFile struct {
+	*fileSize
+	fs *evictingCapacityFs
+}
+
+func (f *evictingFile) Write(p []byte) (n int, err error) {
+	f.fs.policy.Observe(f.Name(), OpWrite)
+
+	err = f.fs.evictAndRetry(func() error {
+		var werr error
+		n, werr = f.fileSize.Write(p)
+		return werr
+	})
+
+	return n, err
+}
+
+func (f *evictingFile) WriteAt(p []byte, off int64) (n int, err error) {
+	f.fs.policy.Observe(f.Name(), OpWrite)
+
+	err = f.fs.evictAndRetry(func() error {
+		var werr error
+		n, werr = f.fileSize.WriteAt(p, off)
+		return werr
+	})
+
+	return n, err
+}
+
+// LRUEviction evicts the least recently observed paths first.
+type LRUEviction struct {
+	mtx    sync.Mutex
+	seq    int64
+	access map[string]int64
+}
+
+// NewLRUEviction returns an EvictionPolicy that evicts the least
+// recently observed path first.
+func NewLRUEviction() *LRUEviction {
+	return &LRUEviction{access: make(map[string]int64)}
+}
+
+func (l *LRUEviction) Observe(path string, op OpKind) {
+	l.mtx.Lock()
+	l.seq++
+	l.access[path] = l.seq
+	l.mtx.Unlock()
+}
+
+func (l *LRUEviction) Evict() []string {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	paths := make([]string, 0, len(l.access))
+	for p := range l.access {
+		paths = append(paths, p)
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return l.access[paths[i]] < l.access[paths[j]]
+	})
+
+	return paths
+}
+
+// Forget stops tracking path, so it is no longer offered as a victim.
+func (l *LRUEviction) Forget(path string) {
+	l.mtx.Lock()
+	delete(l.access, path)
+	l.mtx.Unlock()
+}
+
+// LargestFirstEviction evicts the largest observed paths first. It
+// stats paths against fs at eviction time rather than caching sizes,
+// since a path's size may change between observations.
+type LargestFirstEviction struct {
+	fs afero.Fs
+
+	mtx  sync.Mutex
+	seen map[string]bool
+}
+
+// NewLargestFirstEviction returns an EvictionPolicy that evicts the
+// largest observed path first, sizing paths against fs.
+func NewLargestFirstEviction(fs afero.Fs) *LargestFirstEviction {
+	return &LargestFirstEviction{fs: fs, seen: make(map[string]bool)}
+}
+
+func (l *LargestFirstEviction) Observe(path string, op OpKind) {
+	l.mtx.Lock()
+	l.seen[path] = true
+	l.mtx.Unlock()
+}
+
+func (l *LargestFirstEviction) Evict() []string {
+	l.mtx.Lock()
+	paths := make([]string, 0, len(l.seen))
+	for p := range l.seen {
+		paths = append(paths, p)
+	}
+	l.mtx.Unlock()
+
+	size := func(path string) int64 {
+		stat, err := l.fs.Stat(path)
+		if err != nil {
+			return 0
+		}
+
+		return stat.Size()
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return size(paths[i]) > size(paths[j])
+	})
+
+	return paths
+}
+
+// Forget stops tracking path, so it is no longer offered as a victim.
+func (l *LargestFirstEviction) Forget(path string) {
+	l.mtx.Lock()
+	delete(l.seen, path)
+	l.mtx.Unlock()
+}
+
+// TTLEviction evicts paths that haven't been observed within ttl,
+// oldest first.
+type TTLEviction struct {
+	ttl time.Duration
+
+	mtx  sync.Mutex
+	seen map[string]time.Time
+}
+
+// NewTTLEviction returns an EvictionPolicy that evicts paths whose
+// last observation is older than ttl.
+func NewTTLEviction(ttl time.Duration) *TTLEviction {
+	return &TTLEviction{ttl: ttl, seen: make(map[string]time.Time)}
+}
+
+func (t *TTLEviction) Observe(path string, op OpKind) {
+	t.mtx.Lock()
+	t.seen[path] = time.Now()
+	t.mtx.Unlock()
+}
+
+func (t *TTLEviction) Evict() []string {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	now := time.Now()
+
+	expired := make([]string, 0, len(t.seen))
+	for p, ts := range t.seen {
+		if now.Sub(ts) >= t.ttl {
+			expired = append(expired, p)
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return t.seen[expired[i]].Before(t.seen[expired[j]])
+	})
+
+	return expired
+}
+
+// Forget stops tracking path, so it is no longer offered as a victim.
+func (t *TTLEviction) Forget(path string) {
+	t.mtx.Lock()
+	delete(t.seen, path)
+	t.mtx.Unlock()
+}
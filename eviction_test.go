@@ -0,0 +1,77 @@
+package capacityfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/spf13/afero"
+)
+
+func TestNewCapacityFsWithEvictionLRU(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	cfs, err := NewCapacityFsWithEviction(afs, 42+4, NewLRUEviction())
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(cfs, "old.txt", []byte("asdf"), 0755))
+
+	// touching old.txt records an access, but opening new.txt is
+	// observed after it, so old.txt is still the least recently used
+	// path and is evicted when new.txt's write doesn't fit.
+	_, err = cfs.Open("old.txt")
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(cfs, "new.txt", []byte("ghjk"), 0755))
+
+	ok, err := afero.Exists(afs, "old.txt")
+	is.NoErr(err)
+	is.True(!ok)
+	ok, err = afero.Exists(afs, "new.txt")
+	is.NoErr(err)
+	is.True(ok)
+}
+
+func TestNewCapacityFsWithEvictionLargestFirst(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	cfs, err := NewCapacityFsWithEviction(afs, 42+6, NewLargestFirstEviction(afs))
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(cfs, "big.txt", []byte("asdfgh"), 0755))
+	is.NoErr(afero.WriteFile(cfs, "small.txt", []byte("ok"), 0755))
+
+	ok, err := afero.Exists(afs, "big.txt")
+	is.NoErr(err)
+	is.True(!ok)
+
+	ok, err = afero.Exists(afs, "small.txt")
+	is.NoErr(err)
+	is.True(ok)
+}
+
+func TestTTLEviction(t *testing.T) {
+	is := is.New(t)
+
+	policy := NewTTLEviction(time.Millisecond)
+	policy.Observe("stale.txt", OpWrite)
+
+	time.Sleep(5 * time.Millisecond)
+
+	victims := policy.Evict()
+	is.Equal(len(victims), 1)
+	is.Equal(victims[0], "stale.txt")
+}
+
+func TestEvictionPolicyExhausted(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	cfs, err := NewCapacityFsWithEviction(afs, 42+2, NewLRUEviction())
+	is.NoErr(err)
+
+	err = afero.WriteFile(cfs, "toobig.txt", []byte("way too big"), 0755)
+	is.Equal(err, ErrNotEnoughCapacity)
+}
@@ -8,39 +8,44 @@ import (
 
 type fileSize struct {
 	afero.File
-	// hasEnoughCapacity is the function that fileSize uses to check
-	// if a file can write or not.
-	hasEnoughCapacity func(i int64) bool
-	// addCapacity is a function that adds the size of the file's content.
-	addCapacity func(i int64)
+	// fs is the capacityFs the file was opened from, used to reserve
+	// and commit capacity for writes under a single lock per call.
+	fs *capacityFs
 }
 
 var (
 	ErrNotEnoughCapacity = fmt.Errorf("not enough capacity")
+	// ErrIsDirectory is returned when an operation that expects a file
+	// target is instead given an existing directory, e.g. renaming a
+	// file over a directory.
+	ErrIsDirectory = fmt.Errorf("is a directory")
 )
 
-// Write first checks the size of the byte of slices and returns
-// an error if it larger than the allowed size. Otherwise, it just
-// delegates the Write operation to the underlying file.
+// Write reserves capacity for len(p) bytes before writing, returning
+// ErrNotEnoughCapacity without touching the underlying file if it
+// doesn't fit. The reservation is then committed to the number of
+// bytes actually written, which may be fewer on a short write.
 func (f *fileSize) Write(p []byte) (n int, err error) {
-	if !f.hasEnoughCapacity(int64(len(p))) {
-		return 0, ErrNotEnoughCapacity
+	tok, err := f.fs.reserve(int64(len(p)))
+	if err != nil {
+		return 0, err
 	}
 
 	n, err = f.File.Write(p)
-	f.addCapacity(int64(n))
+	f.fs.commit(tok, int64(n))
 
 	return n, err
 }
 
 // WriteAt is the same as Write but with an offset.
 func (f *fileSize) WriteAt(p []byte, off int64) (n int, err error) {
-	if !f.hasEnoughCapacity(int64(len(p))) {
-		return 0, ErrNotEnoughCapacity
+	tok, err := f.fs.reserve(int64(len(p)))
+	if err != nil {
+		return 0, err
 	}
 
 	n, err = f.File.WriteAt(p, off)
-	f.addCapacity(int64(n))
+	f.fs.commit(tok, int64(n))
 
 	return n, err
 }
@@ -52,6 +57,6 @@ func (f *fileSize) Truncate(size int64) error {
 		return err
 	}
 
-	f.addCapacity(size * -1)
+	f.fs.adjust(size * -1)
 	return err
 }
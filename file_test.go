@@ -26,39 +26,33 @@ func TestFileSize(t *testing.T) {
 	file, err := afs.OpenFile("ok.txt", os.O_CREATE|os.O_RDWR, 0755)
 	is.NoErr(err)
 
-	hasEnoughCapacity := true
-	capacity := int64(0)
+	cfs := &capacityFs{Fs: afs}
 
 	fi := &fileSize{
-		File:              file,
-		hasEnoughCapacity: func(i int64) bool { return hasEnoughCapacity },
-		addCapacity: func(i int64) {
-			capacity += i
-		},
+		File: file,
+		fs:   cfs,
 	}
 
-	hasEnoughCapacity = false
-
 	_, err = fi.Write([]byte("new"))
 	is.Equal(err, ErrNotEnoughCapacity)
 
 	_, err = fi.WriteAt([]byte("new"), 2)
 	is.Equal(err, ErrNotEnoughCapacity)
 
-	hasEnoughCapacity = true
+	cfs.limitedSize = 6
 	_, err = fi.Write([]byte("new"))
 
 	is.NoErr(err)
-	is.Equal(capacity, int64(3))
+	is.Equal(cfs.cachedSize, int64(3))
 
 	_, err = fi.WriteAt([]byte("new"), 0)
 	is.NoErr(err)
-	is.Equal(capacity, int64(6))
+	is.Equal(cfs.cachedSize, int64(6))
 
 	fi.File = &badTruncater{file}
 	is.Equal(fi.Truncate(6), io.ErrUnexpectedEOF)
 	fi.File = file
 	err = fi.Truncate(6)
 	is.NoErr(err)
-	is.Equal(capacity, int64(0))
+	is.Equal(cfs.cachedSize, int64(0))
 }
@@ -1,6 +1,7 @@
 package capacityfs
 
 import (
+	"context"
 	"fmt"
 	"io/fs"
 	"os"
@@ -36,17 +37,70 @@ type capacityFs struct {
 	mtx         sync.RWMutex
 }
 
-// hasEnoughCapacity checks whether or not size can be added or not.
-func (a *capacityFs) hasEnoughCapacity(size int64) bool {
-	a.mtx.RLock()
-	defer a.mtx.RUnlock()
-	return (size + a.cachedSize) <= a.limitedSize
+// adjust adds (or, with a negative size, subtracts) size from
+// cachedSize. Unlike reserve, it never fails: it is used by operations
+// that only ever free or reconcile capacity after the fact (Remove,
+// RemoveAll, Rename, Truncate), where there is nothing to reject.
+func (a *capacityFs) adjust(size int64) {
+	a.mtx.Lock()
+	a.cachedSize += size
+	a.mtx.Unlock()
 }
 
-// addCapacity adds the size to the structure's cachedSize
-func (a *capacityFs) addCapacity(size int64) {
+// capacityToken tracks how many bytes a reservation currently holds
+// against cachedSize, so commit/release know how much to reconcile.
+type capacityToken struct {
+	n int64
+}
+
+// reserve atomically checks that n more bytes fit within limitedSize
+// and, if so, adds them to cachedSize, returning a token that must
+// later be settled with commit or release.
+//
+// Checking and adding under a single a.mtx.Lock (rather than the old
+// pattern of an RLock'd check followed by a separately locked add) is
+// what makes this safe for concurrent writers: two callers racing to
+// reserve the last few bytes of capacity can no longer both pass the
+// check and overshoot limitedSize before either one's add lands.
+func (a *capacityFs) reserve(n int64) (*capacityToken, error) {
 	a.mtx.Lock()
-	a.cachedSize += size
+	defer a.mtx.Unlock()
+
+	if n+a.cachedSize > a.limitedSize {
+		return nil, ErrNotEnoughCapacity
+	}
+
+	a.cachedSize += n
+
+	return &capacityToken{n: n}, nil
+}
+
+// commit settles a token at actualN bytes, reconciling cachedSize for
+// the difference between what was reserved and what was actually used
+// (e.g. a short Write). It returns ErrNotEnoughCapacity, leaving the
+// token untouched, if actualN is larger than what was reserved and the
+// difference doesn't fit.
+func (a *capacityFs) commit(tok *capacityToken, actualN int64) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	delta := actualN - tok.n
+	if delta > 0 && a.cachedSize+delta > a.limitedSize {
+		return ErrNotEnoughCapacity
+	}
+
+	a.cachedSize += delta
+	tok.n = actualN
+
+	return nil
+}
+
+// release gives back a token's reserved bytes entirely, for when a
+// reservation is abandoned without writing anything.
+func (a *capacityFs) release(tok *capacityToken) {
+	a.mtx.Lock()
+	a.cachedSize -= tok.n
+	tok.n = 0
 	a.mtx.Unlock()
 }
 
@@ -57,13 +111,10 @@ func (a *capacityFs) addCapacity(size int64) {
 func (a *capacityFs) statAndCheckSize(name string) error {
 	stat, err := a.Fs.Stat(name)
 	if err == nil {
-		size := stat.Size()
-
-		if !a.hasEnoughCapacity(size) {
-			return ErrNotEnoughCapacity
+		_, err := a.reserve(stat.Size())
+		if err != nil {
+			return err
 		}
-
-		a.addCapacity(size)
 	}
 
 	return nil
@@ -85,7 +136,7 @@ func (a *capacityFs) Create(name string) (afero.File, error) {
 		return nil, err
 	}
 
-	fi, err = &fileSize{fi, a.hasEnoughCapacity, a.addCapacity}, a.statAndCheckSize(name)
+	fi, err = &fileSize{fi, a}, a.statAndCheckSize(name)
 	if err != nil {
 		a.Fs.Remove(name)
 	}
@@ -147,7 +198,7 @@ func (a *capacityFs) Open(name string) (fi afero.File, err error) {
 		return
 	}
 
-	return &fileSize{fi, a.hasEnoughCapacity, a.addCapacity}, nil
+	return &fileSize{fi, a}, nil
 }
 
 // OpenFile opens a File with a size limit
@@ -157,7 +208,7 @@ func (a *capacityFs) OpenFile(name string, flag int, perm os.FileMode) (afero.Fi
 		return nil, err
 	}
 
-	return &fileSize{fi, a.hasEnoughCapacity, a.addCapacity}, nil
+	return &fileSize{fi, a}, nil
 }
 
 // beforeRemove essentially returns the size of the file or the real
@@ -192,7 +243,7 @@ func (a *capacityFs) Remove(name string) error {
 		return err
 	}
 
-	a.addCapacity(size * -1)
+	a.adjust(size * -1)
 
 	return nil
 }
@@ -210,7 +261,50 @@ func (a *capacityFs) RemoveAll(name string) error {
 		return err
 	}
 
-	a.addCapacity(size * -1)
+	a.adjust(size * -1)
+
+	return nil
+}
+
+// Rename renames (moves) a file or directory and keeps cachedSize in
+// sync with the move.
+//
+// If newname already exists, afero's Rename contract allows it to be
+// overwritten, which would otherwise let the overwritten bytes silently
+// drift out of cachedSize. To avoid that, the size of newname (computed
+// via beforeRemove so directories are handled recursively) is debited
+// before the underlying rename happens, and that debit is rolled back if
+// the underlying Fs.Rename fails. Renaming a file over an existing
+// directory is rejected with ErrIsDirectory, matching the semantics of
+// filesystems that refuse to replace a directory with a file.
+func (a *capacityFs) Rename(oldname, newname string) error {
+	oldStat, err := a.Fs.Stat(oldname)
+	if err != nil {
+		return err
+	}
+
+	var delta int64
+	newStat, err := a.Fs.Stat(newname)
+	if err == nil {
+		if newStat.IsDir() && !oldStat.IsDir() {
+			return ErrIsDirectory
+		}
+
+		destSize, err := a.beforeRemove(newname)
+		if err != nil {
+			return err
+		}
+
+		delta = destSize * -1
+	}
+
+	a.adjust(delta)
+
+	err = a.Fs.Rename(oldname, newname)
+	if err != nil {
+		a.adjust(delta * -1)
+		return err
+	}
 
 	return nil
 }
@@ -220,6 +314,54 @@ func (a *capacityFs) Name() string {
 	return fmt.Sprintf("capacityFs %d - %s", a.limitedSize, a.Fs.Name())
 }
 
+// Reservation is a slice of a filesystem's capacity set aside ahead of
+// a write, for callers that know how much they're about to write (e.g.
+// an upload of a known-size stream) and want to fail fast before
+// copying a single byte rather than racing other writers at Write
+// time.
+type Reservation interface {
+	// Commit settles the reservation at actualN bytes, which may be
+	// smaller than originally reserved (a short write) or larger, in
+	// which case it fails with ErrNotEnoughCapacity if the extra bytes
+	// don't fit.
+	Commit(actualN int64) error
+	// Release gives back the reservation without writing anything.
+	Release()
+}
+
+type reservation struct {
+	fs  *capacityFs
+	tok *capacityToken
+}
+
+func (r *reservation) Commit(actualN int64) error {
+	return r.fs.commit(r.tok, actualN)
+}
+
+func (r *reservation) Release() {
+	r.fs.release(r.tok)
+}
+
+// Reserver is implemented by the afero.Fs returned from NewCapacityFs.
+// Callers that can type-assert to it may pre-reserve capacity for a
+// stream of known size before copying it in, the way object-store SDKs
+// call getReaderSize before a transfer.
+type Reserver interface {
+	Reserve(n int64) (Reservation, error)
+}
+
+// Reserve pre-allocates n bytes of capacity, returning
+// ErrNotEnoughCapacity immediately if they don't fit instead of
+// letting a caller discover that partway through a large Write.
+func (a *capacityFs) Reserve(n int64) (Reservation, error) {
+	tok, err := a.reserve(n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reservation{fs: a, tok: tok}, nil
+}
+
 // NewCapacityFs is a function that returns a filesystem of a specific
 // capacity.
 //
@@ -247,3 +389,34 @@ func NewCapacityFs(f afero.Fs, size int64) (afero.Fs, error) {
 		cachedSize:  cachedSize,
 	}, nil
 }
+
+// NewCapacityFsContext is the context-aware counterpart to
+// NewCapacityFs, for callers that want startup latency on large trees
+// bounded by ctx and sped up with SumOptions such as WithConcurrency.
+// It sums the filesystem's existing contents with
+// CalculateSizeSumContext instead of CalculateSizeSum.
+//
+// This function will return an error if the filesystem was above the
+// provided capacity, or if ctx is done before the sum completes.
+func NewCapacityFsContext(ctx context.Context, f afero.Fs, size int64, opts ...SumOption) (afero.Fs, error) {
+	if f == nil {
+		return nil, fmt.Errorf("nil interface")
+	} else if size < 0 {
+		return nil, fmt.Errorf("size less than 0")
+	}
+
+	cachedSize, err := CalculateSizeSumContext(ctx, f, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if cachedSize > size {
+		return nil, fmt.Errorf("%w: %d > %d", ErrNotEnoughCapacity, cachedSize, size)
+	}
+
+	return &capacityFs{
+		Fs:          f,
+		limitedSize: size,
+		cachedSize:  cachedSize,
+	}, nil
+}
@@ -77,22 +77,58 @@ func TestCapacityFsDeferError(t *testing.T) {
 }
 
 // asd
-func TestCapacityFsAddCapacity(t *testing.T) {
+func TestCapacityFsAdjust(t *testing.T) {
 	is := is.New(t)
 
 	fs := &capacityFs{Fs: afero.NewMemMapFs()}
-	fs.addCapacity(5)
+	fs.adjust(5)
 
 	is.Equal(fs.cachedSize, int64(5))
 }
 
-func TestCapacityFsHasEnoughCapacity(t *testing.T) {
+func TestCapacityFsReserveCommitRelease(t *testing.T) {
 	is := is.New(t)
 
 	fs := &capacityFs{Fs: afero.NewMemMapFs()}
-	is.True(!fs.hasEnoughCapacity(5))
+	_, err := fs.reserve(5)
+	is.Equal(err, ErrNotEnoughCapacity)
+
 	fs.limitedSize = 5
-	is.True(fs.hasEnoughCapacity(5))
+	tok, err := fs.reserve(5)
+	is.NoErr(err)
+	is.Equal(fs.cachedSize, int64(5))
+
+	is.NoErr(fs.commit(tok, 3))
+	is.Equal(fs.cachedSize, int64(3))
+
+	tok2, err := fs.reserve(2)
+	is.NoErr(err)
+	is.Equal(fs.cachedSize, int64(5))
+	is.Equal(fs.commit(tok2, 5), ErrNotEnoughCapacity)
+
+	fs.release(tok2)
+	is.Equal(fs.cachedSize, int64(3))
+}
+
+func TestCapacityFsReserve(t *testing.T) {
+	is := is.New(t)
+
+	cfs := &capacityFs{Fs: afero.NewMemMapFs(), limitedSize: 10}
+
+	var reserver Reserver = cfs
+
+	r, err := reserver.Reserve(10)
+	is.NoErr(err)
+
+	_, err = reserver.Reserve(1)
+	is.Equal(err, ErrNotEnoughCapacity)
+
+	r.Release()
+
+	r, err = reserver.Reserve(10)
+	is.NoErr(err)
+	is.NoErr(r.Commit(4))
+	is.Equal(cfs.cachedSize, int64(4))
 }
 
 func TestCapacityStatAndCheckSize(t *testing.T) {
@@ -287,6 +323,49 @@ func TestCapacityFsRemoveAll(t *testing.T) {
 	})
 }
 
+func TestCapacityFsRename(t *testing.T) {
+	is := is.New(t)
+
+	capacityfs, err := NewCapacityFs(afero.NewMemMapFs(), 5000)
+	is.NoErr(err)
+
+	afs := capacityfs.(*capacityFs)
+
+	is.NoErr(afero.WriteFile(afs, "old.txt", []byte("asdf"), 0755))
+	is.NoErr(afero.WriteFile(afs, "new.txt", []byte("asdfgh"), 0755))
+	is.Equal(afs.cachedSize, int64(42+4+6))
+
+	is.NoErr(afs.Rename("old.txt", "new.txt"))
+	is.Equal(afs.cachedSize, int64(42+4))
+
+	is.NoErr(afs.Mkdir("dir1", 0755))
+	err = afs.Rename("new.txt", "dir1")
+	is.Equal(err, ErrIsDirectory)
+
+	_, err = afs.Stat("404")
+	is.True(err != nil)
+	err = afs.Rename("404", "new.txt")
+	is.True(err != nil)
+
+	afs.Fs = &failStatFs{afs.Fs, "new.txt"}
+	err = afs.Rename("new.txt", "new.txt")
+	is.True(err != nil)
+
+	is.NoErr(afero.WriteFile(afs, "old2.txt", []byte("a"), 0755))
+	is.NoErr(afero.WriteFile(afs, "new2.txt", []byte("ab"), 0755))
+	afs.Fs = &removeBadRenameFs{afs.Fs}
+	cachedBefore := afs.cachedSize
+	err = afs.Rename("old2.txt", "new2.txt")
+	is.Equal(err, io.ErrUnexpectedEOF)
+	is.Equal(afs.cachedSize, cachedBefore)
+}
+
+type removeBadRenameFs struct {
+	afero.Fs
+}
+
+func (removeBadRenameFs) Rename(oldname, newname string) error { return io.ErrUnexpectedEOF }
+
 type fsName struct {
 	afero.Fs
 	name string
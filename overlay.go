@@ -0,0 +1,300 @@
+package capacityfs
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// LayerSpec describes one layer of an overlayCapacityFs: the backing
+// filesystem for the layer and the capacity it is allowed to hold.
+type LayerSpec struct {
+	Fs   afero.Fs
+	Size int64
+}
+
+// LayerStat reports the observable state of a single layer, for
+// callers that want visibility into a tiered-storage setup (e.g. how
+// full the hot layer is before falling back to the cold one).
+type LayerStat struct {
+	Name        string
+	LimitedSize int64
+	CachedSize  int64
+}
+
+// overlayCapacityFs composes multiple capacityFs layers into a single
+// afero.Fs. Layers are ordered top to bottom: reads search the layers
+// in order and return the first hit, while writes go to the topmost
+// layer that still has room, mirroring overlayfs-style composition.
+type overlayCapacityFs struct {
+	layers []*capacityFs
+}
+
+// NewOverlayCapacityFs builds an afero.Fs out of independently
+// capacity-limited layers. The first entry in layers is the topmost
+// (preferred) layer.
+//
+// This function will return an error if layers is empty, if any
+// LayerSpec has a nil Fs, or if any layer's existing contents already
+// exceed its own capacity (see NewCapacityFs).
+func NewOverlayCapacityFs(layers []LayerSpec) (afero.Fs, error) {
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers")
+	}
+
+	cfsLayers := make([]*capacityFs, 0, len(layers))
+	for _, l := range layers {
+		if l.Fs == nil {
+			return nil, fmt.Errorf("nil interface")
+		}
+
+		cfs, err := NewCapacityFs(l.Fs, l.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		cfsLayers = append(cfsLayers, cfs.(*capacityFs))
+	}
+
+	return &overlayCapacityFs{layers: cfsLayers}, nil
+}
+
+// LayerStats returns a snapshot of every layer's capacity usage, in
+// the same top-to-bottom order the layers were supplied in.
+func (o *overlayCapacityFs) LayerStats() []LayerStat {
+	stats := make([]LayerStat, len(o.layers))
+	for i, l := range o.layers {
+		l.mtx.RLock()
+		stats[i] = LayerStat{
+			Name:        l.Fs.Name(),
+			LimitedSize: l.limitedSize,
+			CachedSize:  l.cachedSize,
+		}
+		l.mtx.RUnlock()
+	}
+
+	return stats
+}
+
+// findLayer returns the topmost layer that has name, or a not-exist
+// error if no layer has it. It is used by operations on existing paths (Stat, Remove,
+// Rename, Chmod, ...) so the operation runs against whichever layer
+// actually owns the file.
+func (o *overlayCapacityFs) findLayer(name string) (*capacityFs, error) {
+	for _, l := range o.layers {
+		if _, err := l.Fs.Stat(name); err == nil {
+			return l, nil
+		}
+	}
+
+	return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+}
+
+// Create creates name in the topmost layer that still has capacity for
+// it, returning ErrNotEnoughCapacity only once every layer has refused.
+//
+// If name already exists in some layer, Create always reuses that
+// layer instead of picking a fresh one — otherwise a second Create
+// call for the same name could land in a different layer than the
+// first (e.g. once the original layer fills up), leaving two
+// materialized copies where findLayer/Open/Stat only ever see the
+// topmost one and the other silently goes stale.
+func (o *overlayCapacityFs) Create(name string) (afero.File, error) {
+	if l, err := o.findLayer(name); err == nil {
+		return l.Create(name)
+	}
+
+	var lastErr error
+	for _, l := range o.layers {
+		fi, err := l.Create(name)
+		if err == nil {
+			return fi, nil
+		}
+
+		if err != ErrNotEnoughCapacity {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Mkdir creates name in the topmost layer that still has capacity for
+// it, reusing name's existing layer if it already has one (see Create).
+func (o *overlayCapacityFs) Mkdir(name string, perm os.FileMode) error {
+	if l, err := o.findLayer(name); err == nil {
+		return l.Mkdir(name, perm)
+	}
+
+	var lastErr error
+	for _, l := range o.layers {
+		err := l.Mkdir(name, perm)
+		if err == nil {
+			return nil
+		}
+
+		if err != ErrNotEnoughCapacity {
+			return err
+		}
+
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// MkdirAll creates name in the topmost layer that still has capacity
+// for it, reusing name's existing layer if it already has one (see
+// Create).
+func (o *overlayCapacityFs) MkdirAll(name string, perm os.FileMode) error {
+	if l, err := o.findLayer(name); err == nil {
+		return l.MkdirAll(name, perm)
+	}
+
+	var lastErr error
+	for _, l := range o.layers {
+		err := l.MkdirAll(name, perm)
+		if err == nil {
+			return nil
+		}
+
+		if err != ErrNotEnoughCapacity {
+			return err
+		}
+
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// Open opens name from the topmost layer that has it.
+func (o *overlayCapacityFs) Open(name string) (afero.File, error) {
+	var lastErr error
+	for _, l := range o.layers {
+		fi, err := l.Open(name)
+		if err == nil {
+			return fi, nil
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// OpenFile opens name from the topmost layer that has it. With
+// os.O_CREATE, a missing file is created in the topmost layer whose
+// OpenFile does not fail with ErrNotEnoughCapacity, following Create's
+// placement rule; capacityFs.OpenFile does not check capacity itself,
+// so with plain capacityFs layers that is the topmost layer.
+func (o *overlayCapacityFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if l, err := o.findLayer(name); err == nil {
+		return l.OpenFile(name, flag, perm)
+	}
+
+	if flag&os.O_CREATE == 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
+	var lastErr error
+	for _, l := range o.layers {
+		fi, err := l.OpenFile(name, flag, perm)
+		if err == nil {
+			return fi, nil
+		}
+
+		if err != ErrNotEnoughCapacity {
+			return nil, err
+		}
+
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// Remove removes name from whichever layer owns it.
+func (o *overlayCapacityFs) Remove(name string) error {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return err
+	}
+
+	return l.Remove(name)
+}
+
+// RemoveAll removes name from whichever layer owns it.
+func (o *overlayCapacityFs) RemoveAll(name string) error {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return nil
+	}
+
+	return l.RemoveAll(name)
+}
+
+// Rename renames oldname to newname. Both names must resolve to the
+// same layer; overlayCapacityFs does not move files between layers.
+func (o *overlayCapacityFs) Rename(oldname, newname string) error {
+	l, err := o.findLayer(oldname)
+	if err != nil {
+		return err
+	}
+
+	if nl, err := o.findLayer(newname); err == nil && nl != l {
+		return fmt.Errorf("cannot rename across layers: %s is in %s, %s is in %s", oldname, l.Fs.Name(), newname, nl.Fs.Name())
+	}
+
+	return l.Rename(oldname, newname)
+}
+
+// Stat stats name from the topmost layer that has it.
+func (o *overlayCapacityFs) Stat(name string) (os.FileInfo, error) {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return l.Stat(name)
+}
+
+// Name describes the overlay and the number of layers it composes.
+func (o *overlayCapacityFs) Name() string {
+	return fmt.Sprintf("overlayCapacityFs %d layers", len(o.layers))
+}
+
+// Chmod changes the mode of name on whichever layer owns it.
+func (o *overlayCapacityFs) Chmod(name string, mode os.FileMode) error {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return err
+	}
+
+	return l.Fs.Chmod(name, mode)
+}
+
+// Chtimes changes the access and modification times of name on
+// whichever layer owns it.
+func (o *overlayCapacityFs) Chtimes(name string, atime, mtime time.Time) error {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return err
+	}
+
+	return l.Fs.Chtimes(name, atime, mtime)
+}
+
+// Chown changes the owner of name on whichever layer owns it.
+func (o *overlayCapacityFs) Chown(name string, uid, gid int) error {
+	l, err := o.findLayer(name)
+	if err != nil {
+		return err
+	}
+
+	return l.Fs.Chown(name, uid, gid)
+}
@@ -0,0 +1,141 @@
+package capacityfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/spf13/afero"
+)
+
+func TestNewOverlayCapacityFs(t *testing.T) {
+	is := is.New(t)
+
+	_, err := NewOverlayCapacityFs(nil)
+	is.True(err != nil)
+
+	_, err = NewOverlayCapacityFs([]LayerSpec{{Fs: nil, Size: 10}})
+	is.True(err != nil)
+
+	_, err = NewOverlayCapacityFs([]LayerSpec{{Fs: afero.NewMemMapFs(), Size: -1}})
+	is.True(err != nil)
+
+	_, err = NewOverlayCapacityFs([]LayerSpec{{Fs: afero.NewMemMapFs(), Size: 1024}})
+	is.NoErr(err)
+}
+
+func TestOverlayCapacityFsReadWrite(t *testing.T) {
+	is := is.New(t)
+
+	hot := afero.NewMemMapFs()
+	cold := afero.NewMemMapFs()
+
+	// OpenFile (which afero.WriteFile uses for "ok") never precomputes
+	// a file's eventual size, so only Create — which re-Stats the file
+	// right after creating it — can observe that a file is too big for
+	// a layer before any bytes land in it. oversizedStatFs simulates
+	// that the same way TestCapacityFsCreate does for a single
+	// capacityFs, forcing big.txt's post-create stat on hot to read
+	// back oversized, so Create falls through to cold for it.
+	ofs, err := NewOverlayCapacityFs([]LayerSpec{
+		{Fs: &oversizedStatFs{hot, "big.txt"}, Size: 1024},
+		{Fs: cold, Size: 1024},
+	})
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(ofs, "small.txt", []byte("ok"), 0755))
+	ok, err := afero.Exists(hot, "small.txt")
+	is.NoErr(err)
+	is.True(ok)
+
+	f, err := ofs.Create("big.txt")
+	is.NoErr(err)
+	_, err = f.Write([]byte("too big"))
+	is.NoErr(err)
+	is.NoErr(f.Close())
+
+	ok, err = afero.Exists(cold, "big.txt")
+	is.NoErr(err)
+	is.True(ok)
+	ok, err = afero.Exists(hot, "big.txt")
+	is.NoErr(err)
+	is.True(!ok)
+
+	b, err := afero.ReadFile(ofs, "small.txt")
+	is.NoErr(err)
+	is.Equal(string(b), "ok")
+
+	stats := ofs.(*overlayCapacityFs).LayerStats()
+	is.Equal(len(stats), 2)
+	is.Equal(stats[0].CachedSize, int64(42+2))
+	is.Equal(stats[1].CachedSize, int64(42+7))
+}
+
+func TestOverlayCapacityFsFull(t *testing.T) {
+	is := is.New(t)
+
+	ofs, err := NewOverlayCapacityFs([]LayerSpec{
+		{Fs: afero.NewMemMapFs(), Size: 42},
+		{Fs: afero.NewMemMapFs(), Size: 42},
+	})
+	is.NoErr(err)
+
+	err = afero.WriteFile(ofs, "nope.txt", []byte("too big"), 0755)
+	is.Equal(err, ErrNotEnoughCapacity)
+}
+
+func TestOverlayCapacityFsRemoveRename(t *testing.T) {
+	is := is.New(t)
+
+	ofs, err := NewOverlayCapacityFs([]LayerSpec{
+		{Fs: afero.NewMemMapFs(), Size: 1024},
+		{Fs: afero.NewMemMapFs(), Size: 1024},
+	})
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(ofs, "ok.txt", []byte("asdf"), 0755))
+	is.NoErr(ofs.Rename("ok.txt", "ok2.txt"))
+
+	_, err = ofs.Stat("ok.txt")
+	is.True(os.IsNotExist(err))
+
+	is.NoErr(ofs.Remove("ok2.txt"))
+	_, err = ofs.Stat("ok2.txt")
+	is.True(os.IsNotExist(err))
+
+	is.NoErr(ofs.RemoveAll("missing"))
+
+	_, err = ofs.Open("missing")
+	is.True(err != nil)
+}
+
+func TestOverlayCapacityFsRenameAcrossLayers(t *testing.T) {
+	is := is.New(t)
+
+	hot := afero.NewMemMapFs()
+	cold := afero.NewMemMapFs()
+
+	ofs, err := NewOverlayCapacityFs([]LayerSpec{
+		{Fs: hot, Size: 1024},
+		{Fs: cold, Size: 1024},
+	})
+	is.NoErr(err)
+
+	is.NoErr(afero.WriteFile(cold, "b.txt", []byte("cold"), 0755))
+	is.NoErr(afero.WriteFile(ofs, "a.txt", []byte("hot"), 0755))
+
+	err = ofs.Rename("a.txt", "b.txt")
+	is.True(err != nil)
+
+	// neither file should have moved or been duplicated.
+	ok, err := afero.Exists(hot, "a.txt")
+	is.NoErr(err)
+	is.True(ok)
+	ok, err = afero.Exists(hot, "b.txt")
+	is.NoErr(err)
+	is.True(!ok)
+
+	b, err := afero.ReadFile(cold, "b.txt")
+	is.NoErr(err)
+	is.Equal(string(b), "cold")
+}
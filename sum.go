@@ -0,0 +1,133 @@
+package capacityfs
+
+import (
+	"context"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+// sumConfig holds the tunables a SumOption can set on
+// CalculateSizeSumContext.
+type sumConfig struct {
+	concurrency int
+	progress    func(pathsSeen, bytesSeen int64)
+}
+
+// SumOption configures CalculateSizeSumContext.
+type SumOption func(*sumConfig)
+
+// WithConcurrency sets how many directories CalculateSizeSumContext
+// lists at once. Values less than 1 are ignored, keeping the default.
+func WithConcurrency(n int) SumOption {
+	return func(c *sumConfig) {
+		if n > 0 {
+			c.concurrency = n
+		}
+	}
+}
+
+// WithProgress registers a callback invoked as paths are discovered,
+// reporting the running totals seen so far. It may be called
+// concurrently from multiple workers.
+func WithProgress(fn func(pathsSeen, bytesSeen int64)) SumOption {
+	return func(c *sumConfig) {
+		c.progress = fn
+	}
+}
+
+// defaultSumConcurrency is used when WithConcurrency isn't supplied.
+const defaultSumConcurrency = 4
+
+// CalculateSizeSumContext is the concurrent counterpart to
+// CalculateSizeSum. Instead of walking the tree serially via
+// afero.Walk, it fans directory listings out across a bounded worker
+// pool (see WithConcurrency), which matters on large trees where
+// NewCapacityFsContext's startup cost is otherwise dominated by a
+// single-goroutine walk.
+//
+// It respects ctx cancellation: once ctx is done, in-flight workers
+// stop picking up new directories and the first ctx.Err() encountered
+// is returned alongside whatever partial size had been accumulated.
+func CalculateSizeSumContext(ctx context.Context, f afero.Fs, opts ...SumOption) (int64, error) {
+	cfg := &sumConfig{concurrency: defaultSumConcurrency}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	rootInfo, err := f.Stat("")
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		total     int64 = rootInfo.Size()
+		pathsSeen int64
+		wg        sync.WaitGroup
+		errOnce   sync.Once
+		firstErr  error
+	)
+
+	setErr := func(err error) {
+		errOnce.Do(func() { firstErr = err })
+	}
+
+	dirs := make(chan string, cfg.concurrency*4)
+
+	var workers sync.WaitGroup
+	for i := 0; i < cfg.concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+
+			for dir := range dirs {
+				select {
+				case <-ctx.Done():
+					setErr(ctx.Err())
+					wg.Done()
+					continue
+				default:
+				}
+
+				entries, err := afero.ReadDir(f, dir)
+				if err != nil {
+					setErr(err)
+					wg.Done()
+					continue
+				}
+
+				for _, entry := range entries {
+					atomic.AddInt64(&total, entry.Size())
+					seen := atomic.AddInt64(&pathsSeen, 1)
+
+					if entry.IsDir() {
+						wg.Add(1)
+
+						full := path.Join(dir, entry.Name())
+						go func() { dirs <- full }()
+					}
+
+					if cfg.progress != nil {
+						cfg.progress(seen, atomic.LoadInt64(&total))
+					}
+				}
+
+				wg.Done()
+			}
+		}()
+	}
+
+	wg.Add(1)
+	dirs <- ""
+
+	go func() {
+		wg.Wait()
+		close(dirs)
+	}()
+
+	workers.Wait()
+
+	return atomic.LoadInt64(&total), firstErr
+}
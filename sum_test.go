@@ -0,0 +1,65 @@
+package capacityfs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/spf13/afero"
+)
+
+func TestCalculateSizeSumContext(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(afs, "ok.txt", []byte("asdf"), 0755))
+	is.NoErr(afero.WriteFile(afs, "ok2.txt", []byte("asdfgh"), 0755))
+	is.NoErr(afs.Mkdir("dir", 0755))
+	is.NoErr(afero.WriteFile(afs, "dir/last.txt", []byte("oooo"), 0755))
+
+	serial, err := CalculateSizeSum(afs)
+	is.NoErr(err)
+
+	concurrent, err := CalculateSizeSumContext(context.Background(), afs, WithConcurrency(2))
+	is.NoErr(err)
+
+	is.Equal(serial, concurrent)
+}
+
+func TestCalculateSizeSumContextCancel(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	is.NoErr(afs.Mkdir("dir", 0755))
+	is.NoErr(afero.WriteFile(afs, "dir/a.txt", []byte("asdf"), 0755))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := CalculateSizeSumContext(ctx, afs)
+	is.Equal(err, context.Canceled)
+}
+
+func TestCalculateSizeSumContextProgress(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(afs, "ok.txt", []byte("asdf"), 0755))
+
+	var pathsSeen int64
+	_, err := CalculateSizeSumContext(context.Background(), afs, WithProgress(func(paths, bytes int64) {
+		pathsSeen = paths
+	}))
+	is.NoErr(err)
+	is.Equal(pathsSeen, int64(1))
+}
+
+func TestNewCapacityFsContext(t *testing.T) {
+	is := is.New(t)
+
+	afs := afero.NewMemMapFs()
+	is.NoErr(afero.WriteFile(afs, "ok.txt", []byte("asdf"), 0755))
+
+	_, err := NewCapacityFsContext(context.Background(), afs, 50, WithConcurrency(2))
+	is.NoErr(err)
+}